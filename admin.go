@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"CacheProxy/cache"
+)
+
+// entryLister is implemented by cache backends that can enumerate
+// their contents; currently only the in-memory backend supports it.
+// Backends that don't implement it report an empty entry list rather
+// than erroring, since the endpoint is still meaningful as "I can't
+// tell you, but here's everything else".
+type entryLister interface {
+	Entries() []cache.EntryInfo
+}
+
+// newAdminMux builds the admin API: entry listing/invalidation, a
+// full purge, JSON stats, and Prometheus metrics. It is mounted either
+// on its own port (-admin-port) or under /__cache/ on the main port.
+func newAdminMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/entries", handleEntries)
+	mux.HandleFunc("/entries/", handleEntryByKey)
+	mux.HandleFunc("/purge", handlePurge)
+	mux.HandleFunc("/stats", handleStats)
+	mux.Handle("/metrics", promhttp.Handler())
+	return mux
+}
+
+func handleEntries(w http.ResponseWriter, r *http.Request) {
+	lister, ok := store.(entryLister)
+
+	if r.Method == http.MethodDelete {
+		if !ok {
+			http.Error(w, "current cache backend does not support prefix invalidation", http.StatusNotImplemented)
+			return
+		}
+		prefix := r.URL.Query().Get("prefix")
+		for _, e := range lister.Entries() {
+			if strings.HasPrefix(e.Key, prefix) {
+				store.Delete(e.Key)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !ok {
+		json.NewEncoder(w).Encode([]cache.EntryInfo{})
+		return
+	}
+	json.NewEncoder(w).Encode(lister.Entries())
+}
+
+func handleEntryByKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/entries/")
+	if key == "" {
+		http.Error(w, "missing key", http.StatusBadRequest)
+		return
+	}
+	store.Delete(key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handlePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	store.Purge()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Hits        int64 `json:"hits"`
+		Misses      int64 `json:"misses"`
+		Revalidated int64 `json:"revalidated"`
+		BytesServed int64 `json:"bytesServed"`
+	}{
+		Hits:        atomic.LoadInt64(&globalStats.hits),
+		Misses:      atomic.LoadInt64(&globalStats.misses),
+		Revalidated: atomic.LoadInt64(&globalStats.revalidated),
+		BytesServed: atomic.LoadInt64(&globalStats.bytesServed),
+	})
+}
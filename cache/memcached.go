@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// Memcached stores items in a Memcached server.
+type Memcached struct {
+	client *memcache.Client
+}
+
+// NewMemcached returns a Memcached backend talking to the server at
+// addr (host:port).
+func NewMemcached(addr string) *Memcached {
+	return &Memcached{client: memcache.New(addr)}
+}
+
+func (m *Memcached) Get(key string) (Item, bool) {
+	raw, err := m.client.Get(key)
+	if err != nil {
+		return Item{}, false
+	}
+	item, err := decodeItem(raw.Value)
+	if err != nil {
+		return Item{}, false
+	}
+	return item, true
+}
+
+func (m *Memcached) Set(key string, item Item, ttl time.Duration) {
+	raw, err := encodeItem(item)
+	if err != nil {
+		return
+	}
+	m.client.Set(&memcache.Item{
+		Key:        key,
+		Value:      raw,
+		Expiration: memcachedExpiration(ttl),
+	})
+}
+
+// memcachedMaxRelativeSeconds is the memcached protocol's cutover: an
+// Expiration at or below this many seconds is relative to now; above
+// it, the server instead interprets it as an absolute Unix timestamp.
+const memcachedMaxRelativeSeconds = 60 * 60 * 24 * 30
+
+// memcachedExpiration converts ttl into the value memcached's
+// Expiration field expects. cacheTTL can exceed 30 days (e.g. a long
+// s-maxage), and passing that many raw seconds would be read back as
+// an absolute timestamp in the past, expiring the entry immediately;
+// converting to an absolute timestamp ourselves avoids that.
+func memcachedExpiration(ttl time.Duration) int32 {
+	secs := int64(ttl.Seconds())
+	if secs <= memcachedMaxRelativeSeconds {
+		return int32(secs)
+	}
+	return int32(time.Now().Add(ttl).Unix())
+}
+
+func (m *Memcached) Delete(key string) {
+	m.client.Delete(key)
+}
+
+func (m *Memcached) Purge() {
+	m.client.FlushAll()
+}
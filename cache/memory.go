@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Memory is an in-process LRU cache bounded by entry count and,
+// optionally, total body bytes. It is the default backend and the
+// only one that doesn't survive a restart.
+type Memory struct {
+	mu         sync.Mutex
+	maxSize    int
+	maxBytes   int64 // 0 means unbounded
+	totalBytes int64
+	ll         *list.List
+	entries    map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	item    Item
+	expires time.Time // zero means no expiry
+	hits    int64
+}
+
+// NewMemory returns a Memory cache that evicts the least recently used
+// entry once more than maxSize keys are stored, or once the sum of
+// cached body sizes would exceed maxBytes (0 disables the byte budget).
+func NewMemory(maxSize int, maxBytes int64) *Memory {
+	if maxSize <= 0 {
+		maxSize = 1024
+	}
+	return &Memory{
+		maxSize:  maxSize,
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (m *Memory) Get(key string) (Item, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	el, ok := m.entries[key]
+	if !ok {
+		return Item{}, false
+	}
+	entry := el.Value.(*memoryEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		m.removeElement(el)
+		return Item{}, false
+	}
+	entry.hits++
+	m.ll.MoveToFront(el)
+	return entry.item, true
+}
+
+func (m *Memory) Set(key string, item Item, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	if el, ok := m.entries[key]; ok {
+		m.ll.MoveToFront(el)
+		entry := el.Value.(*memoryEntry)
+		m.totalBytes += int64(len(item.Body)) - int64(len(entry.item.Body))
+		entry.item = item
+		entry.expires = expires
+	} else {
+		el := m.ll.PushFront(&memoryEntry{key: key, item: item, expires: expires})
+		m.entries[key] = el
+		m.totalBytes += int64(len(item.Body))
+	}
+
+	for m.ll.Len() > m.maxSize || (m.maxBytes > 0 && m.totalBytes > m.maxBytes) {
+		if m.ll.Len() == 0 {
+			break
+		}
+		m.removeOldest()
+	}
+}
+
+func (m *Memory) Delete(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.entries[key]; ok {
+		m.removeElement(el)
+	}
+}
+
+func (m *Memory) Purge() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ll.Init()
+	m.entries = make(map[string]*list.Element)
+	m.totalBytes = 0
+}
+
+// Entries returns a snapshot of every entry currently stored, for the
+// admin API.
+func (m *Memory) Entries() []EntryInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make([]EntryInfo, 0, len(m.entries))
+	for _, el := range m.entries {
+		e := el.Value.(*memoryEntry)
+		out = append(out, EntryInfo{
+			Key:  e.key,
+			Size: len(e.item.Body),
+			Age:  time.Since(e.item.CachedAt),
+			Hits: e.hits,
+		})
+	}
+	return out
+}
+
+func (m *Memory) removeOldest() {
+	el := m.ll.Back()
+	if el != nil {
+		m.removeElement(el)
+	}
+}
+
+func (m *Memory) removeElement(el *list.Element) {
+	m.ll.Remove(el)
+	entry := el.Value.(*memoryEntry)
+	m.totalBytes -= int64(len(entry.item.Body))
+	delete(m.entries, entry.key)
+}
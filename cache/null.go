@@ -0,0 +1,14 @@
+package cache
+
+import "time"
+
+// NullCache is a Provider that stores nothing, turning the proxy into
+// a plain pass-through. Useful for benchmarking the overhead of the
+// caching layer itself, or for disabling caching without branching in
+// handler code.
+type NullCache struct{}
+
+func (NullCache) Get(key string) (Item, bool)           { return Item{}, false }
+func (NullCache) Set(key string, item Item, ttl time.Duration) {}
+func (NullCache) Delete(key string)                     {}
+func (NullCache) Purge()                                {}
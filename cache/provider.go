@@ -0,0 +1,99 @@
+// Package cache defines the storage abstraction used by the proxy and
+// the set of backends (in-memory LRU, Redis, Memcached, and a
+// pass-through null cache) that implement it.
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Item is a stored response: its status, body and headers, plus the
+// time it was written to the cache. Freshness is computed by the
+// caller from the headers, not stored redundantly here.
+type Item struct {
+	StatusCode int
+	Body       []byte
+	Headers    http.Header
+	CachedAt   time.Time
+}
+
+// EntryInfo describes a cached entry for backends that support
+// enumeration (currently Memory only), used by the admin API.
+type EntryInfo struct {
+	Key  string
+	Size int
+	Age  time.Duration
+	Hits int64
+}
+
+// Provider is the storage interface every cache backend implements.
+// Implementations must be safe for concurrent use.
+type Provider interface {
+	// Get returns the item stored under key, if any and not expired.
+	Get(key string) (Item, bool)
+	// Set stores item under key. ttl of zero means no expiry is
+	// enforced by the backend itself (the caller is expected to
+	// recheck freshness on every Get).
+	Set(key string, item Item, ttl time.Duration)
+	// Delete removes key from the cache, if present.
+	Delete(key string)
+	// Purge removes every entry from the cache.
+	Purge()
+}
+
+// New builds a Provider from a backend URL such as
+// "memory://?size=1024&bytes=104857600", "redis://host:6379/0" or
+// "memcached://host:11211". It is the single entry point the proxy
+// uses to select a backend at startup via the -cache flag.
+func New(rawURL string) (Provider, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("cache: invalid backend URL %q: %w", rawURL, err)
+	}
+
+	switch u.Scheme {
+	case "", "memory":
+		size := 1024
+		if v := u.Query().Get("size"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("cache: invalid memory size %q: %w", v, err)
+			}
+			size = n
+		}
+		var maxBytes int64
+		if v := u.Query().Get("bytes"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("cache: invalid memory bytes %q: %w", v, err)
+			}
+			maxBytes = n
+		}
+		return NewMemory(size, maxBytes), nil
+
+	case "redis":
+		db := 0
+		if u.Path != "" && u.Path != "/" {
+			n, err := strconv.Atoi(strings.TrimPrefix(u.Path, "/"))
+			if err != nil {
+				return nil, fmt.Errorf("cache: invalid redis db %q: %w", u.Path, err)
+			}
+			db = n
+		}
+		return NewRedis(u.Host, db)
+
+	case "memcached":
+		return NewMemcached(u.Host), nil
+
+	case "null", "none":
+		return NullCache{}, nil
+
+	default:
+		return nil, fmt.Errorf("cache: unknown backend scheme %q", u.Scheme)
+	}
+}
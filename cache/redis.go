@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis stores items in a Redis server, making cached responses
+// available across restarts and shared by every proxy instance
+// pointed at the same server.
+type Redis struct {
+	client *redis.Client
+	ctx    context.Context
+}
+
+// NewRedis connects to the Redis server at addr (host:port) and
+// selects db.
+func NewRedis(addr string, db int) (*Redis, error) {
+	client := redis.NewClient(&redis.Options{Addr: addr, DB: db})
+	ctx := context.Background()
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("cache: connecting to redis at %s: %w", addr, err)
+	}
+	return &Redis{client: client, ctx: ctx}, nil
+}
+
+func (r *Redis) Get(key string) (Item, bool) {
+	raw, err := r.client.Get(r.ctx, key).Bytes()
+	if err != nil {
+		return Item{}, false
+	}
+	item, err := decodeItem(raw)
+	if err != nil {
+		return Item{}, false
+	}
+	return item, true
+}
+
+func (r *Redis) Set(key string, item Item, ttl time.Duration) {
+	raw, err := encodeItem(item)
+	if err != nil {
+		return
+	}
+	r.client.Set(r.ctx, key, raw, ttl)
+}
+
+func (r *Redis) Delete(key string) {
+	r.client.Del(r.ctx, key)
+}
+
+func (r *Redis) Purge() {
+	r.client.FlushDB(r.ctx)
+}
+
+func encodeItem(item Item) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(item); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeItem(raw []byte) (Item, error) {
+	var item Item
+	if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&item); err != nil {
+		return Item{}, err
+	}
+	return item, nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cacheControl holds the directives relevant to freshness and storage
+// decisions that we care about from a parsed Cache-Control header.
+type cacheControl struct {
+	NoStore                 bool
+	NoCache                 bool
+	Private                 bool
+	Public                  bool
+	MustRevalidate          bool
+	MaxAge                  int
+	HasMaxAge               bool
+	SMaxage                 int
+	HasSMaxage              bool
+	StaleWhileRevalidate    int
+	HasStaleWhileRevalidate bool
+}
+
+// parseCacheControl parses a Cache-Control header value into its
+// directives. Unknown directives are ignored.
+func parseCacheControl(header string) cacheControl {
+	var cc cacheControl
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, hasValue := part, "", false
+		if idx := strings.Index(part, "="); idx != -1 {
+			name = strings.TrimSpace(part[:idx])
+			value = strings.Trim(strings.TrimSpace(part[idx+1:]), `"`)
+			hasValue = true
+		}
+		switch strings.ToLower(name) {
+		case "no-store":
+			cc.NoStore = true
+		case "no-cache":
+			cc.NoCache = true
+		case "private":
+			cc.Private = true
+		case "public":
+			cc.Public = true
+		case "must-revalidate":
+			cc.MustRevalidate = true
+		case "max-age":
+			if hasValue {
+				if secs, err := strconv.Atoi(value); err == nil {
+					cc.MaxAge = secs
+					cc.HasMaxAge = true
+				}
+			}
+		case "s-maxage":
+			if hasValue {
+				if secs, err := strconv.Atoi(value); err == nil {
+					cc.SMaxage = secs
+					cc.HasSMaxage = true
+				}
+			}
+		case "stale-while-revalidate":
+			if hasValue {
+				if secs, err := strconv.Atoi(value); err == nil {
+					cc.StaleWhileRevalidate = secs
+					cc.HasStaleWhileRevalidate = true
+				}
+			}
+		}
+	}
+	return cc
+}
+
+// freshnessLifetime computes how long a response may be served from
+// cache without revalidation, per RFC 7234 section 4.2.1. s-maxage
+// takes precedence over max-age, which in turn takes precedence over
+// Expires, since this is a shared cache; if none are present the
+// response is considered immediately stale.
+func freshnessLifetime(cc cacheControl, expiresHeader, dateHeader string, cachedAt time.Time) time.Duration {
+	if cc.HasSMaxage {
+		return time.Duration(cc.SMaxage) * time.Second
+	}
+	if cc.HasMaxAge {
+		return time.Duration(cc.MaxAge) * time.Second
+	}
+	if expiresHeader == "" {
+		return 0
+	}
+	expires, err := http.ParseTime(expiresHeader)
+	if err != nil {
+		return 0
+	}
+	date := cachedAt
+	if dateHeader != "" {
+		if d, err := http.ParseTime(dateHeader); err == nil {
+			date = d
+		}
+	}
+	return expires.Sub(date)
+}
+
+// isStorable reports whether a response may be stored at all, based on
+// the directives present on it.
+func (cc cacheControl) isStorable() bool {
+	return !cc.NoStore
+}
+
+// isStorableForAuthorizedRequest reports whether a shared cache may
+// store a response to a request that carried an Authorization header.
+// RFC 7234 section 3.2 forbids this unless the response is explicitly
+// marked public, must-revalidate, or carries s-maxage.
+func (cc cacheControl) isStorableForAuthorizedRequest() bool {
+	return cc.Public || cc.MustRevalidate || cc.HasSMaxage
+}
+
+// varyKey builds the portion of a cache key derived from the headers
+// named in a Vary response header, so that entries are only reused for
+// requests that vary identically. An empty or "*" Vary header is
+// returned as-is by the caller; callers must treat "*" as never
+// cacheable across requests.
+func varyKey(r *http.Request, varyHeader string) string {
+	if varyHeader == "" {
+		return ""
+	}
+	var b strings.Builder
+	for _, name := range strings.Split(varyHeader, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(r.Header.Get(name))
+		b.WriteByte(';')
+	}
+	return b.String()
+}
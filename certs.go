@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	caCert *x509.Certificate
+	caKey  *rsa.PrivateKey
+
+	leafMu    sync.Mutex
+	leafCerts = make(map[string]*tls.Certificate)
+)
+
+// loadCA reads the CA certificate and private key used to sign leaf
+// certificates generated for MITM interception.
+func loadCA(certFile, keyFile string) error {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("loading CA key pair: %w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("parsing CA certificate: %w", err)
+	}
+	rsaKey, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return fmt.Errorf("CA private key must be RSA")
+	}
+
+	caCert = cert
+	caKey = rsaKey
+	return nil
+}
+
+// leafCertFor returns a TLS certificate for host signed by the
+// configured CA, generating and caching it in memory on first use.
+func leafCertFor(host string) (*tls.Certificate, error) {
+	leafMu.Lock()
+	defer leafMu.Unlock()
+
+	if cert, ok := leafCerts[host]; ok {
+		return cert, nil
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("generating leaf key: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, fmt.Errorf("signing leaf certificate: %w", err)
+	}
+
+	cert := &tls.Certificate{
+		Certificate: [][]byte{der, caCert.Raw},
+		PrivateKey:  key,
+	}
+	leafCerts[host] = cert
+	return cert, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/tls"
+	"io"
+	"log"
+	"net"
+	"net/http"
+)
+
+// mitmEnabled selects between transparent CONNECT tunneling (the
+// default) and MITM interception, which requires a CA loaded via
+// loadCA.
+var mitmEnabled bool
+
+// handleConnect dispatches a CONNECT request to either a transparent
+// tunnel or the MITM interception path.
+func handleConnect(w http.ResponseWriter, r *http.Request) {
+	if mitmEnabled {
+		mitmConnect(w, r)
+		return
+	}
+	tunnelConnect(w, r)
+}
+
+// tunnelConnect splices bytes between the client and the target
+// verbatim, without decrypting or caching anything.
+func tunnelConnect(w http.ResponseWriter, r *http.Request) {
+	destConn, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		destConn.Close()
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	clientConn, _, err := hijacker.Hijack()
+	if err != nil {
+		destConn.Close()
+		return
+	}
+
+	clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+
+	go splice(destConn, clientConn)
+	go splice(clientConn, destConn)
+}
+
+func splice(dst, src net.Conn) {
+	defer dst.Close()
+	defer src.Close()
+	io.Copy(dst, src)
+}
+
+// mitmConnect terminates TLS on the proxy using a leaf certificate
+// generated for the requested host, then serves the decrypted HTTP
+// requests through the normal caching pipeline as if they had arrived
+// over plain HTTP for that host.
+func mitmConnect(w http.ResponseWriter, r *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	clientConn, _, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		clientConn.Close()
+		return
+	}
+
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	cert, err := leafCertFor(host)
+	if err != nil {
+		log.Printf("mitm: generating certificate for %s: %v", host, err)
+		clientConn.Close()
+		return
+	}
+
+	tlsConn := tls.Server(clientConn, &tls.Config{Certificates: []tls.Certificate{*cert}})
+
+	origin := "https://" + host
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		HandleRequest(w, req, origin)
+	})
+
+	srv := &http.Server{Handler: handler}
+	srv.Serve(&singleConnListener{conn: tlsConn})
+}
+
+// singleConnListener is a net.Listener that yields a single,
+// already-established connection and then reports no more are coming.
+// It lets us reuse http.Server (and its request parsing) to serve the
+// decrypted MITM connection without hand-rolling HTTP parsing.
+type singleConnListener struct {
+	conn net.Conn
+	used bool
+}
+
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	if l.used {
+		return nil, io.EOF
+	}
+	l.used = true
+	return l.conn, nil
+}
+
+func (l *singleConnListener) Close() error   { return nil }
+func (l *singleConnListener) Addr() net.Addr { return l.conn.LocalAddr() }
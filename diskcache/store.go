@@ -0,0 +1,372 @@
+// Package diskcache provides a filesystem-backed cache mode intended
+// for large responses (package mirrors, container layers) that would
+// be impractical to hold in memory. Each entry is a body file plus a
+// sidecar JSON metadata file, and concurrent requests for a URL that
+// is still downloading attach as live readers of the same file instead
+// of triggering duplicate origin fetches.
+package diskcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metadata is the sidecar JSON written next to each cached body file.
+type Metadata struct {
+	URL           string
+	StatusCode    int
+	Headers       http.Header
+	ETag          string
+	LastModified  string
+	ContentLength int64
+	CachedAt      time.Time
+	MaxAge        time.Duration
+	Completed     bool
+}
+
+// download tracks an in-flight fetch so that other requests for the
+// same key can attach as live readers instead of re-fetching. The
+// status code and headers are published as soon as they're known, so
+// a live reader can mirror the primary requester's response line
+// instead of falling back to a bare 200.
+type download struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	written int64
+	done    bool
+	err     error
+
+	headersReady bool
+	statusCode   int
+	headers      http.Header
+}
+
+// Store is a filesystem-backed cache. It is safe for concurrent use.
+type Store struct {
+	dir    string
+	maxAge time.Duration
+
+	mu       sync.Mutex
+	inFlight map[string]*download
+}
+
+// NewStore returns a Store rooted at dir, creating it if necessary.
+// maxAge bounds how long a completed entry is served before it is
+// re-downloaded from origin.
+func NewStore(dir string, maxAge time.Duration) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("diskcache: creating %s: %w", dir, err)
+	}
+	return &Store{
+		dir:      dir,
+		maxAge:   maxAge,
+		inFlight: make(map[string]*download),
+	}, nil
+}
+
+// key hashes the canonical request URL into a filesystem-safe name.
+func key(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Store) paths(k string) (bodyPath, metaPath string) {
+	return filepath.Join(s.dir, k+".body"), filepath.Join(s.dir, k+".meta.json")
+}
+
+func (s *Store) readMeta(metaPath string) (Metadata, bool) {
+	f, err := os.Open(metaPath)
+	if err != nil {
+		return Metadata{}, false
+	}
+	defer f.Close()
+
+	var meta Metadata
+	if err := json.NewDecoder(f).Decode(&meta); err != nil {
+		return Metadata{}, false
+	}
+	return meta, true
+}
+
+func (s *Store) writeMeta(metaPath string, meta Metadata) error {
+	tmp := metaPath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(meta); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, metaPath)
+}
+
+// Fetch serves originURL from the disk cache, downloading it from
+// origin first if needed. It supports Range requests against
+// completed entries and coalesces concurrent requests for the same
+// in-flight download.
+func (s *Store) Fetch(w http.ResponseWriter, r *http.Request, originURL string) {
+	k := key(originURL)
+	bodyPath, metaPath := s.paths(k)
+
+	if meta, ok := s.readMeta(metaPath); ok && meta.Completed && time.Since(meta.CachedAt) < s.effectiveMaxAge(meta) {
+		s.serveFromDisk(w, r, bodyPath, meta)
+		return
+	}
+
+	s.mu.Lock()
+	if dl, ok := s.inFlight[k]; ok {
+		s.mu.Unlock()
+		s.attachLiveReader(w, dl, bodyPath)
+		return
+	}
+	dl := &download{}
+	dl.cond = sync.NewCond(&dl.mu)
+	s.inFlight[k] = dl
+	s.mu.Unlock()
+
+	s.runDownload(w, dl, originURL, bodyPath, metaPath)
+}
+
+func (s *Store) effectiveMaxAge(meta Metadata) time.Duration {
+	if meta.MaxAge > 0 {
+		return meta.MaxAge
+	}
+	return s.maxAge
+}
+
+// serveFromDisk streams a completed entry to the client, honoring
+// Range requests via http.ServeContent.
+func (s *Store) serveFromDisk(w http.ResponseWriter, r *http.Request, bodyPath string, meta Metadata) {
+	f, err := os.Open(bodyPath)
+	if err != nil {
+		http.Error(w, "Error reading cached entry", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	for k, v := range meta.Headers {
+		w.Header()[k] = v
+	}
+	w.Header().Set("X-Cache", "HIT")
+	http.ServeContent(w, r, "", meta.CachedAt, f)
+}
+
+// isCacheableStatus reports whether status is eligible for storage at
+// all; only successful responses are, per RFC 7234 section 3.
+func isCacheableStatus(status int) bool {
+	return status >= 200 && status < 300
+}
+
+// hasNoStore reports whether a Cache-Control header carries the
+// no-store directive.
+func hasNoStore(cacheControl string) bool {
+	for _, part := range strings.Split(cacheControl, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), "no-store") {
+			return true
+		}
+	}
+	return false
+}
+
+// runDownload fetches originURL, streaming the response simultaneously
+// to the requesting client and to the body file on disk, broadcasting
+// progress so that attachLiveReader callers can tail it. Only a
+// cacheable (2xx, non-no-store) response is written to disk and
+// recorded in the sidecar metadata; anything else is streamed straight
+// through so a transient origin error or an explicitly non-storable
+// response can never be served back as a cache HIT.
+func (s *Store) runDownload(w http.ResponseWriter, dl *download, originURL, bodyPath, metaPath string) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.inFlight, key(originURL))
+		s.mu.Unlock()
+	}()
+
+	fail := func(err error, status int) {
+		dl.mu.Lock()
+		dl.err = err
+		dl.done = true
+		dl.cond.Broadcast()
+		dl.mu.Unlock()
+		http.Error(w, err.Error(), status)
+	}
+
+	res, err := http.Get(originURL)
+	if err != nil {
+		fail(fmt.Errorf("fetching from origin: %w", err), http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+
+	cacheable := isCacheableStatus(res.StatusCode) && !hasNoStore(res.Header.Get("Cache-Control"))
+
+	dl.mu.Lock()
+	dl.statusCode = res.StatusCode
+	dl.headers = res.Header
+	dl.headersReady = true
+	dl.cond.Broadcast()
+	dl.mu.Unlock()
+
+	for k, v := range res.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("X-Cache", "MISS")
+	w.WriteHeader(res.StatusCode)
+
+	if !cacheable {
+		io.Copy(w, res.Body)
+		dl.mu.Lock()
+		dl.done = true
+		dl.cond.Broadcast()
+		dl.mu.Unlock()
+		return
+	}
+
+	file, err := os.Create(bodyPath)
+	if err != nil {
+		fail(fmt.Errorf("creating cache file: %w", err), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	progress := &progressWriter{dl: dl}
+	mw := io.MultiWriter(w, file, progress)
+	if _, err := io.Copy(mw, res.Body); err != nil {
+		dl.mu.Lock()
+		dl.err = err
+		dl.done = true
+		dl.cond.Broadcast()
+		dl.mu.Unlock()
+		return
+	}
+
+	meta := Metadata{
+		URL:           originURL,
+		StatusCode:    res.StatusCode,
+		Headers:       res.Header,
+		ETag:          res.Header.Get("ETag"),
+		LastModified:  res.Header.Get("Last-Modified"),
+		ContentLength: res.ContentLength,
+		CachedAt:      time.Now(),
+		Completed:     true,
+	}
+	if err := s.writeMeta(metaPath, meta); err != nil {
+		log := fmt.Sprintf("diskcache: writing metadata for %s: %v", originURL, err)
+		_ = log // metadata write failures don't affect the client response already sent
+	}
+
+	dl.mu.Lock()
+	dl.done = true
+	dl.cond.Broadcast()
+	dl.mu.Unlock()
+}
+
+// progressWriter advances a download's written offset and wakes any
+// live readers waiting on new bytes.
+type progressWriter struct {
+	dl *download
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.dl.mu.Lock()
+	p.dl.written += int64(len(b))
+	p.dl.cond.Broadcast()
+	p.dl.mu.Unlock()
+	return len(b), nil
+}
+
+// attachLiveReader tails bodyPath as it grows, writing new bytes to w
+// as they're flushed to disk by the in-flight download, until the
+// download completes or fails. It first mirrors the in-flight
+// download's status and headers, so an attached client sees the same
+// response line the primary requester got instead of a default 200
+// with no Content-Type or Content-Length.
+func (s *Store) attachLiveReader(w http.ResponseWriter, dl *download, bodyPath string) {
+	dl.mu.Lock()
+	for !dl.headersReady && !dl.done {
+		dl.cond.Wait()
+	}
+	statusCode, headers, failed := dl.statusCode, dl.headers, dl.done && dl.err != nil
+	dl.mu.Unlock()
+
+	if failed {
+		http.Error(w, "Error fetching from origin", http.StatusBadGateway)
+		return
+	}
+
+	for k, v := range headers {
+		w.Header()[k] = v
+	}
+	w.Header().Set("X-Cache", "MISS")
+	w.WriteHeader(statusCode)
+
+	var file *os.File
+	for file == nil {
+		f, err := os.Open(bodyPath)
+		if err == nil {
+			file = f
+			break
+		}
+		dl.mu.Lock()
+		if dl.done {
+			dl.mu.Unlock()
+			// The in-flight download finished without ever creating a
+			// body file, meaning its response wasn't cacheable; there is
+			// nothing left on disk to tail.
+			return
+		}
+		dl.cond.Wait()
+		dl.mu.Unlock()
+	}
+	defer file.Close()
+
+	flusher, _ := w.(http.Flusher)
+	var offset int64
+	buf := make([]byte, 32*1024)
+
+	for {
+		dl.mu.Lock()
+		for dl.written == offset && !dl.done {
+			dl.cond.Wait()
+		}
+		written, done, err := dl.written, dl.done, dl.err
+		dl.mu.Unlock()
+
+		for offset < written {
+			n, readErr := file.ReadAt(buf[:min(len(buf), int(written-offset))], offset)
+			if n > 0 {
+				w.Write(buf[:n])
+				offset += int64(n)
+				if flusher != nil {
+					flusher.Flush()
+				}
+			}
+			if readErr != nil && readErr != io.EOF {
+				return
+			}
+		}
+
+		if done {
+			if err != nil {
+				// The download failed after its status line had been
+				// sent, so no error status can follow; live readers just
+				// stop here with a truncated body.
+				return
+			}
+			return
+		}
+	}
+}
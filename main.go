@@ -1,103 +1,465 @@
 package main
 
 import (
-	"encoding/json"
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"sync"
+	"strconv"
+	"strings"
 	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"CacheProxy/cache"
+	"CacheProxy/diskcache"
 )
 
 var (
-	cache      = make(map[string]CacheItem) // Map to store cache items
-	cacheMutex = sync.RWMutex{}             // Mutex to handle access to the cache
+	store cache.Provider
+	disk  *diskcache.Store
+
+	// fetchGroup coalesces concurrent origin fetches for the same
+	// cache key, so that N simultaneous misses result in exactly one
+	// upstream request instead of N.
+	fetchGroup singleflight.Group
 )
 
-// CacheItem represents a cached response
-type CacheItem struct {
-	Response []byte      // The actual response body
-	Headers  http.Header // The headers of the response
-	CachedAt time.Time   // Time when the response was cached
-}
+// revalidationGrace bounds how long a response that has gone stale is
+// still kept around for conditional revalidation before the backend is
+// allowed to evict it outright.
+const revalidationGrace = time.Hour
 
 func main() {
 	port := flag.Int("port", 6000, "Port on which the proxy server will run")
 	origin := flag.String("origin", "", "The origin server to forward requests to")
+	backend := flag.String("cache", "memory://?size=1024", "Cache backend URL: memory://, redis://host:port/db, memcached://host:port, or null://")
+	diskCacheDir := flag.String("disk-cache-dir", "", "If set, stream and cache responses as files under this directory instead of in memory (for large binaries)")
+	diskCacheMaxAge := flag.Duration("disk-cache-max-age", time.Hour, "How long a disk-cached entry is served before being re-downloaded from origin")
+	mitm := flag.Bool("mitm", false, "Intercept CONNECT requests by MITM instead of transparently tunneling them (requires -ca-cert/-ca-key)")
+	caCertFile := flag.String("ca-cert", "", "CA certificate used to sign MITM leaf certificates")
+	caKeyFile := flag.String("ca-key", "", "CA private key used to sign MITM leaf certificates")
+	adminPort := flag.Int("admin-port", 0, "Port for a separate admin API server (entries, stats, purge, metrics); if 0, it is mounted under /__cache/ on the main port")
 	flag.Parse()
 
 	if *origin == "" {
 		log.Fatal("Origin not specified")
 	}
 
+	var err error
+	store, err = cache.New(*backend)
+	if err != nil {
+		log.Fatalf("Failed to initialize cache backend %q: %v", *backend, err)
+	}
+
+	if *diskCacheDir != "" {
+		disk, err = diskcache.NewStore(*diskCacheDir, *diskCacheMaxAge)
+		if err != nil {
+			log.Fatalf("Failed to initialize disk cache: %v", err)
+		}
+	}
+
+	if *mitm {
+		if *caCertFile == "" || *caKeyFile == "" {
+			log.Fatal("-mitm requires -ca-cert and -ca-key")
+		}
+		if err := loadCA(*caCertFile, *caKeyFile); err != nil {
+			log.Fatalf("Failed to load MITM CA: %v", err)
+		}
+		mitmEnabled = true
+	}
+
+	if lister, ok := store.(entryLister); ok {
+		go reportCacheGauges(lister)
+	}
+
+	adminMux := newAdminMux()
+	if *adminPort > 0 {
+		go func() {
+			log.Printf("Admin API listening on port %d\n", *adminPort)
+			log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *adminPort), adminMux))
+		}()
+	} else {
+		http.Handle("/__cache/", http.StripPrefix("/__cache", adminMux))
+	}
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			handleConnect(w, r)
+			return
+		}
+
+		start := time.Now()
+		defer func() { cacheLatency.Observe(time.Since(start).Seconds()) }()
+
+		if disk != nil {
+			disk.Fetch(w, r, fmt.Sprintf("%s%s", *origin, r.URL.RequestURI()))
+			return
+		}
 		HandleRequest(w, r, *origin)
 	})
 
 	// Start the proxy server
-	log.Printf("Proxy started on port %d, forwarding to %s\n", *port, *origin)
+	log.Printf("Proxy started on port %d, forwarding to %s, cache backend %s\n", *port, *origin, *backend)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *port), nil))
 }
 
+// HandleRequest serves r from cache when possible, otherwise fetches
+// from origin, storing the response according to its Cache-Control,
+// Expires and Vary headers so that future requests can reuse it.
 func HandleRequest(w http.ResponseWriter, r *http.Request, origin string) {
-	originURL := fmt.Sprintf("%s%s", origin, r.URL.Path)
+	originURL := fmt.Sprintf("%s%s", origin, r.URL.RequestURI())
+	key := cacheKey(r, originURL)
 
-	// Check if the URL is already in cache
-	cacheMutex.RLock()
-	cacheItem, found := cache[originURL]
-	cacheMutex.RUnlock()
+	item, found := store.Get(key)
 
 	if found {
-		// If found in cache, return the cached response
-		for k, v := range cacheItem.Headers {
-			w.Header()[k] = v // Copy cached headers to the response
+		cc := parseCacheControl(item.Headers.Get("Cache-Control"))
+		fresh := freshFor(item, cc)
+		if fresh >= 0 {
+			writeCached(w, item, "HIT")
+			return
+		}
+
+		if cc.HasStaleWhileRevalidate && fresh+time.Duration(cc.StaleWhileRevalidate)*time.Second >= 0 {
+			writeCached(w, item, "HIT")
+			go revalidate(origin, r, key, item)
+			return
+		}
+
+		revalidated, ok := doRevalidate(origin, r, key, item)
+		if ok {
+			writeCached(w, revalidated, "REVALIDATED")
+			return
+		}
+	}
+
+	fetchAndServe(w, r, origin, key)
+}
+
+// freshFor returns the remaining freshness lifetime of a cached item,
+// which is negative once it has gone stale.
+func freshFor(item cache.Item, cc cacheControl) time.Duration {
+	lifetime := freshnessLifetime(cc, item.Headers.Get("Expires"), item.Headers.Get("Date"), item.CachedAt)
+	return lifetime - currentAge(item)
+}
+
+// initialAge computes the RFC 7234 section 4.2.3 age a response had
+// the moment it was received, from its own Age and Date headers. This
+// proxy doesn't track request/response transit delay separately, so
+// request_time and response_time collapse into the single receivedAt
+// instant the caller passes.
+func initialAge(headers http.Header, receivedAt time.Time) time.Duration {
+	var ageValue time.Duration
+	if raw := headers.Get("Age"); raw != "" {
+		if secs, err := strconv.Atoi(raw); err == nil {
+			ageValue = time.Duration(secs) * time.Second
 		}
-		w.Header().Set("X-Cache", "HIT")
-		w.Write(cacheItem.Response) // Write cached response body
+	}
+
+	var apparentAge time.Duration
+	if dateHeader := headers.Get("Date"); dateHeader != "" {
+		if date, err := http.ParseTime(dateHeader); err == nil {
+			if d := receivedAt.Sub(date); d > 0 {
+				apparentAge = d
+			}
+		}
+	}
+
+	if ageValue > apparentAge {
+		return ageValue
+	}
+	return apparentAge
+}
+
+// currentAge returns how old an item is right now: the age it already
+// had when it was cached, plus how long it has sat in the cache since
+// (RFC 7234 section 4.2.3's initial_age and resident_time).
+func currentAge(item cache.Item) time.Duration {
+	return initialAge(item.Headers, item.CachedAt) + time.Since(item.CachedAt)
+}
+
+// cacheKey derives the cache key for a request. The Vary header of a
+// previously cached response (if any) is folded into the key so that
+// responses varying on request headers don't collide. The bare
+// originURL doubles as an index: storeItem keeps it populated with the
+// most recent response's headers specifically so this lookup can
+// discover the current Vary header before the vary-keyed entry itself
+// is known to exist.
+func cacheKey(r *http.Request, originURL string) string {
+	existing, found := store.Get(originURL)
+	if !found {
+		return originURL
+	}
+	vary := existing.Headers.Get("Vary")
+	if vary == "" || vary == "*" {
+		return originURL
+	}
+	return originURL + "|" + varyKey(r, vary)
+}
+
+// storeItem writes item under its resolved cache key. When that key
+// differs from the bare origin URL (because the response carries a
+// Vary header), it also refreshes the bare-URL entry so the next
+// cacheKey lookup — which only ever starts from the bare URL — can
+// discover the current Vary header and re-derive the same key. Without
+// this, the first response would be stored only under the bare URL,
+// every subsequent request would derive a vary-keyed key that was
+// never written to, and the entry would miss and be re-fetched forever.
+//
+// A response whose Vary is "*" is never cacheable (RFC 7234 §4.1) and
+// must not be written at all, including to the bare-URL index.
+func storeItem(originURL, key string, item cache.Item, ttl time.Duration) {
+	if item.Headers.Get("Vary") == "*" {
 		return
 	}
+	store.Set(key, item, ttl)
+	if key != originURL {
+		store.Set(originURL, item, ttl)
+	}
+}
+
+// writeCached copies a cached entry's headers and body to the client,
+// annotating the response with X-Cache and a correct Age header.
+func writeCached(w http.ResponseWriter, item cache.Item, result string) {
+	for k, v := range item.Headers {
+		w.Header()[k] = v
+	}
+	w.Header().Set("X-Cache", result)
+	w.Header().Set("Age", strconv.Itoa(int(currentAge(item).Seconds())))
+	w.WriteHeader(item.StatusCode)
+	w.Write(item.Body)
+	recordResult(result, len(item.Body))
+}
 
-	// If not found in cache, fetch from origin
-	res, err := http.Get(originURL)
+// doRevalidate issues a conditional GET against origin using the
+// entry's ETag/Last-Modified and returns the refreshed entry on a 304,
+// or ok=false if the origin returned a new representation (the caller
+// should then fall through to a normal fetch).
+func doRevalidate(origin string, r *http.Request, key string, item cache.Item) (cache.Item, bool) {
+	originURL := fmt.Sprintf("%s%s", origin, r.URL.RequestURI())
+	req, err := http.NewRequest(http.MethodGet, originURL, nil)
 	if err != nil {
-		http.Error(w, "Error fetching from origin", http.StatusBadGateway)
-		return
+		return cache.Item{}, false
+	}
+	if etag := item.Headers.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastMod := item.Headers.Get("Last-Modified"); lastMod != "" {
+		req.Header.Set("If-Modified-Since", lastMod)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return cache.Item{}, false
 	}
 	defer res.Body.Close()
 
-	var responseData map[string]interface{}
-	err = json.NewDecoder(res.Body).Decode(&responseData)
+	if res.StatusCode != http.StatusNotModified {
+		return cache.Item{}, false
+	}
+
+	updated := item
+	updated.CachedAt = time.Now()
+
+	// item.Headers may be the live map returned by the cache backend
+	// (e.g. cache.Memory.Get hands back its stored map directly), so it
+	// must not be mutated in place: a concurrent reader iterating it for
+	// another request would race with this write.
+	newHeaders := make(http.Header, len(item.Headers))
+	for k, v := range item.Headers {
+		newHeaders[k] = v
+	}
+	for k, v := range res.Header {
+		newHeaders[k] = v
+	}
+	updated.Headers = newHeaders
+
+	cc := parseCacheControl(updated.Headers.Get("Cache-Control"))
+	storeItem(originURL, key, updated, cacheTTL(cc))
+
+	return updated, true
+}
+
+// revalidate is the background counterpart of doRevalidate, used when
+// serving a stale-while-revalidate hit: the stale copy has already been
+// written to the client, so errors here are logged rather than surfaced.
+func revalidate(origin string, r *http.Request, key string, item cache.Item) {
+	if _, ok := doRevalidate(origin, r, key, item); !ok {
+		log.Printf("background revalidation failed for %s", key)
+	}
+}
+
+// singleflightKey extends a cache key with any credential-bearing
+// request headers. cacheKey alone is not enough to coalesce on: a
+// response that turns out to be private/no-store is never stored, so
+// cacheKey keeps resolving to the bare URL on every request to that
+// path, which would otherwise coalesce different users' requests onto
+// one shared (and possibly personalized) response.
+func singleflightKey(r *http.Request, key string) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		key += "|auth=" + auth
+	}
+	if cookie := r.Header.Get("Cookie"); cookie != "" {
+		key += "|cookie=" + cookie
+	}
+	return key
+}
+
+// originResponse is the coalesced result of a single origin fetch,
+// shared by every waiter on the same singleflight key.
+type originResponse struct {
+	StatusCode int
+	Headers    http.Header
+	Body       []byte
+}
+
+// fetchAndServe fetches originURL, writes it to the client, and stores
+// it in the cache if its Cache-Control/status permit storage. Concurrent
+// GET misses for the same key are coalesced via fetchGroup so only one
+// request reaches the origin; other methods bypass coalescing entirely,
+// since fetchOrigin now forwards the request body and two concurrent
+// non-idempotent requests (e.g. differing POST bodies) must not be
+// collapsed into one origin call sharing a single body and response.
+func fetchAndServe(w http.ResponseWriter, r *http.Request, origin, key string) {
+	originURL := fmt.Sprintf("%s%s", origin, r.URL.RequestURI())
+
+	var v interface{}
+	var err error
+	if r.Method == http.MethodGet {
+		v, err, _ = fetchGroup.Do(singleflightKey(r, key), func() (interface{}, error) {
+			return fetchOrigin(r, originURL)
+		})
+	} else {
+		v, err = fetchOrigin(r, originURL)
+	}
 	if err != nil {
-		http.Error(w, "Error decoding response from origin", http.StatusInternalServerError)
+		http.Error(w, "Error fetching from origin", http.StatusBadGateway)
 		return
 	}
+	res := v.(originResponse)
+	receivedAt := time.Now()
+
+	cc := parseCacheControl(res.Headers.Get("Cache-Control"))
 
+	for k, v := range res.Headers {
+		w.Header()[k] = v
+	}
 	w.Header().Set("X-Cache", "MISS")
-	if err := json.NewEncoder(w).Encode(responseData); err != nil {
-		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+	w.Header().Set("Age", strconv.Itoa(int(initialAge(res.Headers, receivedAt).Seconds())))
+	w.WriteHeader(res.StatusCode)
+	w.Write(res.Body)
+	recordResult("MISS", len(res.Body))
+
+	if !cc.isStorable() || cc.NoCache || cc.Private {
 		return
 	}
-
-	// Cache the response for future requests
-	cacheMutex.Lock()
-	body, err := json.Marshal(responseData)
-	if err != nil {
-		http.Error(w, "Error encoding response for cache", http.StatusInternalServerError)
-		cacheMutex.Unlock()
+	if r.Header.Get("Range") != "" || res.StatusCode == http.StatusPartialContent {
+		// A partial response must never be stored as if it were the
+		// complete representation: a later non-Range request would be
+		// served a 206 truncated to whatever byte range the original
+		// requester happened to ask for.
+		return
+	}
+	if r.Header.Get("Authorization") != "" && !cc.isStorableForAuthorizedRequest() {
 		return
 	}
 
-	// Copy headers to avoid modifying the original headers
 	copiedHeaders := make(http.Header)
-	for k, v := range res.Header {
+	for k, v := range res.Headers {
 		copiedHeaders[k] = v
 	}
 
-	cache[originURL] = CacheItem{
-		Response: body,          // Store the body in the cache
-		Headers:  copiedHeaders, // Store the headers
-		CachedAt: time.Now(),    // Record when cached
+	item := cache.Item{
+		StatusCode: res.StatusCode,
+		Body:       res.Body,
+		Headers:    copiedHeaders,
+		CachedAt:   receivedAt,
+	}
+
+	storeItem(originURL, key, item, cacheTTL(cc))
+}
+
+// fetchOrigin performs the actual origin request, forwarding the
+// coalescing leader's method, body and headers so a POST/PUT isn't
+// silently downgraded to a GET and conditional/Range/Accept-Encoding/
+// Authorization headers reach origin as the client sent them. Its
+// result is shared across every request coalesced onto it, so it must
+// not otherwise depend on any particular waiter's http.ResponseWriter.
+func fetchOrigin(r *http.Request, originURL string) (originResponse, error) {
+	var reqBody []byte
+	if r.Body != nil {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			return originResponse{}, fmt.Errorf("reading request body: %w", err)
+		}
+		reqBody = b
+	}
+
+	req, err := http.NewRequest(r.Method, originURL, bytes.NewReader(reqBody))
+	if err != nil {
+		return originResponse{}, fmt.Errorf("building origin request: %w", err)
+	}
+	copyRequestHeaders(req.Header, r.Header)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return originResponse{}, fmt.Errorf("fetching from origin: %w", err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return originResponse{}, fmt.Errorf("reading response from origin: %w", err)
+	}
+
+	return originResponse{StatusCode: res.StatusCode, Headers: res.Header, Body: body}, nil
+}
+
+// hopByHopHeaders are the RFC 7230 section 6.1 headers that describe a
+// single connection rather than the resource itself, and so must not
+// be relayed end-to-end by a proxy.
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailers", "Transfer-Encoding", "Upgrade",
+}
+
+// copyRequestHeaders copies src into dst, skipping hop-by-hop headers.
+func copyRequestHeaders(dst, src http.Header) {
+	for k, v := range src {
+		hopByHop := false
+		for _, h := range hopByHopHeaders {
+			if strings.EqualFold(k, h) {
+				hopByHop = true
+				break
+			}
+		}
+		if !hopByHop {
+			dst[k] = v
+		}
+	}
+}
+
+// cacheTTL picks how long the backend itself should retain an entry.
+// This is deliberately looser than the freshness lifetime so that
+// stale entries remain available for conditional revalidation instead
+// of being evicted outright. s-maxage takes precedence over max-age,
+// same as freshnessLifetime, since this is a shared cache. Expires is
+// not consulted here, so an entry whose freshness comes from Expires
+// alone is retained for revalidationGrace (plus any
+// stale-while-revalidate window), even when Expires lies further out.
+func cacheTTL(cc cacheControl) time.Duration {
+	lifetime := time.Duration(0)
+	switch {
+	case cc.HasSMaxage:
+		lifetime = time.Duration(cc.SMaxage) * time.Second
+	case cc.HasMaxAge:
+		lifetime = time.Duration(cc.MaxAge) * time.Second
+	}
+	if cc.HasStaleWhileRevalidate {
+		lifetime += time.Duration(cc.StaleWhileRevalidate) * time.Second
 	}
-	cacheMutex.Unlock()
+	return lifetime + revalidationGrace
 }
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_requests_total",
+		Help: "Total proxied requests by cache result (hit, miss, revalidated).",
+	}, []string{"result"})
+
+	cacheBytesGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cache_bytes",
+		Help: "Total bytes currently held in the cache.",
+	})
+
+	cacheEntriesGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cache_entries",
+		Help: "Number of entries currently held in the cache.",
+	})
+
+	cacheLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "cache_latency_seconds",
+		Help:    "Latency of proxied requests, from receipt to response written.",
+		Buckets: prometheus.DefBuckets,
+	})
+)
+
+// reportCacheGauges periodically refreshes cache_bytes and
+// cache_entries from a backend that supports enumeration.
+func reportCacheGauges(lister entryLister) {
+	for range time.Tick(10 * time.Second) {
+		entries := lister.Entries()
+		var bytes int64
+		for _, e := range entries {
+			bytes += int64(e.Size)
+		}
+		cacheEntriesGauge.Set(float64(len(entries)))
+		cacheBytesGauge.Set(float64(bytes))
+	}
+}
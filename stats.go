@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// stats holds the plain counters backing the admin /stats endpoint;
+// each update also feeds the corresponding Prometheus metric.
+type stats struct {
+	hits        int64
+	misses      int64
+	revalidated int64
+	bytesServed int64
+}
+
+var globalStats stats
+
+// recordResult records a completed request's cache result and the
+// number of body bytes written to the client.
+func recordResult(result string, bytes int) {
+	switch result {
+	case "HIT":
+		atomic.AddInt64(&globalStats.hits, 1)
+	case "MISS":
+		atomic.AddInt64(&globalStats.misses, 1)
+	case "REVALIDATED":
+		atomic.AddInt64(&globalStats.revalidated, 1)
+	}
+	atomic.AddInt64(&globalStats.bytesServed, int64(bytes))
+	requestsTotal.WithLabelValues(strings.ToLower(result)).Inc()
+}